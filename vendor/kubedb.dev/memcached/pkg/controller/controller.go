@@ -0,0 +1,81 @@
+/*
+Copyright The KubeDB Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	cs "kubedb.dev/apimachinery/client/clientset/versioned"
+
+	promclientset "github.com/coreos/prometheus-operator/pkg/client/versioned"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	mcsclientset "sigs.k8s.io/mcs-api/pkg/client/clientset/versioned"
+)
+
+// Controller reconciles Memcached resources. Only the fields touched by
+// this package are declared here; the rest of the wiring (informers,
+// queues, config) lives alongside the other controllers in this binary.
+type Controller struct {
+	Client    kubernetes.Interface
+	ExtClient cs.Interface
+	// MCSClient talks to the Kubernetes Multi-Cluster Services API
+	// (multicluster.x-k8s.io) and is nil-safe to call only after
+	// mcsCRDsInstalled has been checked.
+	MCSClient mcsclientset.Interface
+	// mcsInstalled caches whether the multicluster.x-k8s.io CRDs are
+	// registered with the API server. Populated once at startup by
+	// DetectOptionalCRDs so reconciles never hit discovery directly.
+	mcsInstalled bool
+	// serviceImportIndexer backs syncServiceImportEndpoints; it is set up
+	// by RunServiceImportInformer before it waits for the informer to sync,
+	// and nil until RunServiceImportInformer has run.
+	serviceImportIndexer cache.Indexer
+	// serviceImportQueue decouples the ServiceImport informer's event
+	// handlers from syncServiceImportEndpoints: AddFunc/UpdateFunc only
+	// enqueue a namespace/name key, and the workers started by
+	// RunServiceImportInformer drain it, so a slow or conflicting status
+	// update never blocks informer event delivery and gets retried with
+	// backoff instead of being dropped. Set up by RunServiceImportInformer.
+	serviceImportQueue workqueue.RateLimitingInterface
+
+	// PromClient talks to the Prometheus Operator CRDs
+	// (monitoring.coreos.com) and is nil-safe to call only after
+	// prometheusOperatorCRDsInstalled has been checked.
+	PromClient promclientset.Interface
+	// promInstalled caches whether the Prometheus Operator CRDs are
+	// registered with the API server. Populated once at startup by
+	// DetectOptionalCRDs so reconciles never hit discovery directly.
+	promInstalled bool
+	// dualStackSupported caches whether the API server advertises both IP
+	// families. Populated once at startup by DetectOptionalCRDs so
+	// validateDualStackSupport never re-derives it per reconcile.
+	dualStackSupported bool
+
+	recorder record.EventRecorder
+}
+
+// DetectOptionalCRDs probes, once at controller startup, for CRDs and
+// cluster capabilities that gate optional reconciliation paths (MCS
+// ServiceExport/ServiceImport, the Prometheus Operator's
+// ServiceMonitor/PodMonitor, dual-stack support) so the hot reconcile
+// path never needs to call discovery or the apiserver for them itself.
+func (c *Controller) DetectOptionalCRDs() {
+	c.detectMCSCRDs()
+	c.detectPrometheusOperatorCRDs()
+	c.detectDualStackSupport()
+}
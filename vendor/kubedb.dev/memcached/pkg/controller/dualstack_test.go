@@ -0,0 +1,93 @@
+/*
+Copyright The KubeDB Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateDualStackSupport(t *testing.T) {
+	requireDualStack := core.IPFamilyPolicyRequireDualStack
+
+	m := &api.Memcached{}
+	m.Spec.IPFamilyPolicy = &requireDualStack
+
+	c := &Controller{dualStackSupported: false}
+	if err := c.validateDualStackSupport(m); err == nil {
+		t.Fatal("expected an error when RequireDualStack is requested but unsupported")
+	}
+
+	c = &Controller{dualStackSupported: true}
+	if err := c.validateDualStackSupport(m); err != nil {
+		t.Fatalf("expected no error when the cluster supports dual-stack, got %v", err)
+	}
+
+	preferDualStack := core.IPFamilyPolicyPreferDualStack
+	m.Spec.IPFamilyPolicy = &preferDualStack
+	c = &Controller{dualStackSupported: false}
+	if err := c.validateDualStackSupport(m); err != nil {
+		t.Fatalf("PreferDualStack should never be rejected, got %v", err)
+	}
+}
+
+// TestCreateService_SingleStackIPv6OnlyCluster simulates the scenario the
+// backlog explicitly asked to cover: a single-stack, IPv6-only cluster
+// (dualStackSupported is false, as on any cluster that was never
+// configured with a second --service-cluster-ip-range). A Memcached
+// requesting plain SingleStack with an IPv6 family must still be
+// admitted and produce a Service with the right spec.ipFamilies/
+// spec.ipFamilyPolicy — this isn't a RequireDualStack request, so
+// validateDualStackSupport must not reject it.
+func TestCreateService_SingleStackIPv6OnlyCluster(t *testing.T) {
+	singleStack := core.IPFamilyPolicySingleStack
+
+	memcached := &api.Memcached{}
+	memcached.Name = "ipv6-only"
+	memcached.Namespace = "demo"
+	memcached.Spec.IPFamilyPolicy = &singleStack
+	memcached.Spec.Network.IPFamilies = []core.IPFamily{core.IPv6Protocol}
+
+	c := &Controller{
+		Client:             fake.NewSimpleClientset(),
+		dualStackSupported: false,
+	}
+
+	if err := c.validateDualStackSupport(memcached); err != nil {
+		t.Fatalf("SingleStack IPv6 must be admitted on a single-stack cluster, got %v", err)
+	}
+
+	if _, err := c.createService(memcached); err != nil {
+		t.Fatalf("createService failed: %v", err)
+	}
+
+	svc, err := c.Client.CoreV1().Services(memcached.Namespace).Get(memcached.OffshootName(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the db Service to have been created: %v", err)
+	}
+	if svc.Spec.IPFamilyPolicy == nil || *svc.Spec.IPFamilyPolicy != core.IPFamilyPolicySingleStack {
+		t.Fatalf("expected spec.ipFamilyPolicy=SingleStack, got %+v", svc.Spec.IPFamilyPolicy)
+	}
+	if len(svc.Spec.IPFamilies) != 1 || svc.Spec.IPFamilies[0] != core.IPv6Protocol {
+		t.Fatalf("expected spec.ipFamilies=[IPv6], got %+v", svc.Spec.IPFamilies)
+	}
+}
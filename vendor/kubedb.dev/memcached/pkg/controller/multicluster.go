@@ -0,0 +1,281 @@
+/*
+Copyright The KubeDB Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/pkg/eventer"
+	util "kubedb.dev/apimachinery/pkg/util"
+
+	"github.com/appscode/go/log"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	kutil "kmodules.xyz/client-go"
+	mona "kmodules.xyz/monitoring-agent-api/api/v1"
+	mcs "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+	mcsinformers "sigs.k8s.io/mcs-api/pkg/client/informers/externalversions"
+)
+
+// serviceImportWorkers is the number of workers draining
+// serviceImportQueue. ServiceImport churn is low (one per exported
+// Memcached), so a single worker is plenty; named as a constant so it
+// reads as a deliberate choice rather than a magic number.
+const serviceImportWorkers = 1
+
+// ensureMulticluster reconciles the ServiceExport(s) for a Memcached and
+// refreshes Status.Multicluster.Endpoints from the matching ServiceImport,
+// if any has already been resolved by the MCS controller. It is called
+// from ensureService on every reconcile, in addition to the ServiceImport
+// informer kept up to date by RunServiceImportInformer.
+func (c *Controller) ensureMulticluster(memcached *api.Memcached) error {
+	if _, err := c.ensureServiceExports(memcached); err != nil {
+		return err
+	}
+	return c.syncServiceImportEndpoints(memcached)
+}
+
+// The multicluster.x-k8s.io rule this subsystem needs (get/list/watch/
+// create/update on serviceexports and serviceimports, so it can export the
+// db/stats Services and watch for the ServiceImport the MCS controller
+// writes back) is granted in deploy/kubedb/templates/clusterrole.yaml,
+// alongside the existing core/v1 Service rule.
+
+// ensureServiceExports reconciles a ServiceExport for the database Service
+// and, when enabled, the Prometheus stats Service so that a single
+// Memcached CR can serve clients from other clusters in the same
+// ClusterSet without users hand-crafting exports.
+func (c *Controller) ensureServiceExports(memcached *api.Memcached) (kutil.VerbType, error) {
+	if !memcached.Spec.Multicluster.Enabled {
+		return kutil.VerbUnchanged, nil
+	}
+	if !c.mcsCRDsInstalled() {
+		log.Infoln("multicluster.x-k8s.io CRDs are not installed; skipping ServiceExport reconciliation")
+		return kutil.VerbUnchanged, nil
+	}
+
+	vt, err := c.ensureServiceExport(memcached, memcached.ServiceName())
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	}
+
+	if memcached.GetMonitoringVendor() == mona.VendorPrometheus {
+		if _, err := c.ensureServiceExport(memcached, memcached.StatsService().ServiceName()); err != nil {
+			return kutil.VerbUnchanged, err
+		}
+	}
+
+	if vt != kutil.VerbUnchanged {
+		c.recorder.Eventf(
+			memcached,
+			core.EventTypeNormal,
+			eventer.EventReasonSuccessful,
+			"Successfully %s ServiceExport",
+			vt,
+		)
+	}
+	return vt, nil
+}
+
+// ensureServiceExport creates the ServiceExport for serviceName if it does
+// not already exist. ServiceExport has no spec to reconcile, so unlike the
+// Service helpers in service.go this is create-only.
+func (c *Controller) ensureServiceExport(memcached *api.Memcached, serviceName string) (kutil.VerbType, error) {
+	_, err := c.MCSClient.MulticlusterV1alpha1().ServiceExports(memcached.Namespace).Get(serviceName, metav1.GetOptions{})
+	if err == nil {
+		return kutil.VerbUnchanged, nil
+	}
+	if !kerr.IsNotFound(err) {
+		return kutil.VerbUnchanged, err
+	}
+
+	owner := metav1.NewControllerRef(memcached, api.SchemeGroupVersion.WithKind(api.ResourceKindMemcached))
+	export := &mcs.ServiceExport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            serviceName,
+			Namespace:       memcached.Namespace,
+			Labels:          memcached.OffshootLabels(),
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+	}
+	if _, err := c.MCSClient.MulticlusterV1alpha1().ServiceExports(memcached.Namespace).Create(export); err != nil {
+		return kutil.VerbUnchanged, fmt.Errorf("failed to export service %v/%v: %w", memcached.Namespace, serviceName, err)
+	}
+	return kutil.VerbCreated, nil
+}
+
+// mcsCRDsInstalled reports whether the multicluster.x-k8s.io CRDs are
+// registered with the API server. The result is discovered once, at
+// controller startup (see DetectOptionalCRDs), and cached on the
+// Controller so the reconcile hot path never re-hits discovery.
+func (c *Controller) mcsCRDsInstalled() bool {
+	return c.mcsInstalled
+}
+
+// detectMCSCRDs is invoked once from DetectOptionalCRDs at controller
+// startup to populate mcsInstalled.
+func (c *Controller) detectMCSCRDs() {
+	_, err := c.Client.Discovery().ServerResourcesForGroupVersion(mcs.SchemeGroupVersion.String())
+	c.mcsInstalled = err == nil
+}
+
+// syncServiceImportEndpoints mirrors the resolved cluster-set VIP/hostname
+// of the Memcached's ServiceImport, if already cached by the
+// ServiceImport informer, onto Memcached.Status.Multicluster.Endpoints.
+func (c *Controller) syncServiceImportEndpoints(memcached *api.Memcached) error {
+	if !memcached.Spec.Multicluster.Enabled || !c.mcsCRDsInstalled() {
+		return nil
+	}
+	// Nothing is cached until RunServiceImportInformer has set up the indexer.
+	if c.serviceImportIndexer == nil {
+		return nil
+	}
+
+	obj, exists, err := c.serviceImportIndexer.GetByKey(memcached.Namespace + "/" + memcached.ServiceName())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	imp := obj.(*mcs.ServiceImport)
+
+	endpoints := make([]api.MulticlusterEndpoint, 0, len(imp.Spec.IPs))
+	for _, ip := range imp.Spec.IPs {
+		endpoints = append(endpoints, api.MulticlusterEndpoint{
+			ClusterSet: imp.Annotations["multicluster.x-k8s.io/clusterset"],
+			Address:    ip,
+		})
+	}
+
+	_, err = util.UpdateMemcachedStatus(c.ExtClient.KubedbV1alpha1(), memcached, func(in *api.MemcachedStatus) *api.MemcachedStatus {
+		in.Multicluster.Endpoints = endpoints
+		return in
+	}, api.UpdateOptions{})
+	return err
+}
+
+// RunServiceImportInformer starts the ServiceImport informer used by
+// syncServiceImportEndpoints, as requested: the controller watches
+// ServiceImport objects instead of polling them on every reconcile. It is
+// started once from the main controller Run loop, alongside the other
+// informers for this controller. It returns at once, doing nothing, when
+// the MCS CRDs are not installed; otherwise it blocks until the informer's
+// cache has synced, and the informer and workers then run until stopCh is
+// closed.
+// The informer's own event handlers only enqueue a key onto
+// serviceImportQueue; serviceImportWorkers workers drain that queue and do
+// the actual Get+status-Update, so a slow API call or a conflicting status
+// update never blocks informer event delivery and is retried with backoff
+// instead of silently dropped.
+func (c *Controller) RunServiceImportInformer(stopCh <-chan struct{}) {
+	if !c.mcsCRDsInstalled() {
+		return
+	}
+
+	c.serviceImportQueue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	factory := mcsinformers.NewSharedInformerFactory(c.MCSClient, 0)
+	informer := factory.Multicluster().V1alpha1().ServiceImports().Informer()
+	c.serviceImportIndexer = informer.GetIndexer()
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.enqueueServiceImport(obj) },
+		UpdateFunc: func(_, obj interface{}) { c.enqueueServiceImport(obj) },
+	})
+
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		runtime.HandleError(fmt.Errorf("timed out waiting for ServiceImport cache to sync"))
+		return
+	}
+
+	for i := 0; i < serviceImportWorkers; i++ {
+		go wait.Until(c.runServiceImportWorker, 0, stopCh)
+	}
+
+	go func() {
+		<-stopCh
+		c.serviceImportQueue.ShutDown()
+	}()
+}
+
+// enqueueServiceImport converts a ServiceImport add/update into a
+// namespace/name key on serviceImportQueue. It does no API calls itself,
+// so it never blocks the informer's event-delivery goroutine.
+func (c *Controller) enqueueServiceImport(obj interface{}) {
+	imp, ok := obj.(*mcs.ServiceImport)
+	if !ok {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(imp)
+	if err != nil {
+		runtime.HandleError(err)
+		return
+	}
+	c.serviceImportQueue.Add(key)
+}
+
+// runServiceImportWorker drains serviceImportQueue until it is shut down.
+func (c *Controller) runServiceImportWorker() {
+	for c.processNextServiceImportItem() {
+	}
+}
+
+// processNextServiceImportItem pops a single key off serviceImportQueue and
+// re-syncs the owning Memcached's status from it, retrying with backoff on
+// failure (AddRateLimited) instead of swallowing the error.
+func (c *Controller) processNextServiceImportItem() bool {
+	key, quit := c.serviceImportQueue.Get()
+	if quit {
+		return false
+	}
+	defer c.serviceImportQueue.Done(key)
+
+	if err := c.syncServiceImportByKey(key.(string)); err != nil {
+		runtime.HandleError(fmt.Errorf("failed to sync ServiceImport %v: %w", key, err))
+		c.serviceImportQueue.AddRateLimited(key)
+		return true
+	}
+
+	c.serviceImportQueue.Forget(key)
+	return true
+}
+
+// syncServiceImportByKey looks up the Memcached named by a ServiceImport
+// key and re-derives its Status.Multicluster.Endpoints from the cache kept
+// up to date by the informer in RunServiceImportInformer.
+func (c *Controller) syncServiceImportByKey(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	memcached, err := c.ExtClient.KubedbV1alpha1().Memcacheds(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return c.syncServiceImportEndpoints(memcached)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright The KubeDB Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWantsHeadlessService(t *testing.T) {
+	cases := []struct {
+		name      string
+		memcached *api.Memcached
+		want      bool
+	}{
+		{
+			name:      "headless flag unset",
+			memcached: &api.Memcached{},
+			want:      false,
+		},
+		{
+			name: "headless flag set",
+			memcached: func() *api.Memcached {
+				m := &api.Memcached{}
+				m.Spec.Headless = true
+				return m
+			}(),
+			want: true,
+		},
+		{
+			name: "primary ServiceTemplate ClusterIP=None does not imply a companion service",
+			memcached: func() *api.Memcached {
+				m := &api.Memcached{}
+				m.Spec.ServiceTemplate.Spec.ClusterIP = core.ClusterIPNone
+				return m
+			}(),
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := wantsHeadlessService(tc.memcached); got != tc.want {
+				t.Fatalf("wantsHeadlessService() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateHeadlessCompatibility(t *testing.T) {
+	m := &api.Memcached{}
+	m.Spec.Headless = true
+	m.Spec.ServiceTemplate.Spec.Type = core.ServiceTypeLoadBalancer
+
+	if err := validateHeadlessCompatibility(m); err == nil {
+		t.Fatal("expected an error for Headless + LoadBalancer, got nil")
+	}
+
+	m.Spec.ServiceTemplate.Spec.Type = core.ServiceTypeClusterIP
+	if err := validateHeadlessCompatibility(m); err != nil {
+		t.Fatalf("expected no error for Headless + ClusterIP, got %v", err)
+	}
+}
+
+// TestCreateServices_HeadlessKeepsPrimaryServiceNormal runs the two Service
+// builders that ensureService calls, createService and
+// createHeadlessService, against a fake clientset: with Spec.Headless set,
+// both the regular db Service and the "<offshoot>-pods" headless companion
+// must be created, and the regular one must NOT also end up headless.
+func TestCreateServices_HeadlessKeepsPrimaryServiceNormal(t *testing.T) {
+	memcached := &api.Memcached{}
+	memcached.Name = "sharded"
+	memcached.Namespace = "demo"
+	memcached.Spec.Headless = true
+	memcached.Spec.PublishNotReadyAddresses = true
+
+	c := &Controller{Client: fake.NewSimpleClientset()}
+
+	if _, err := c.createService(memcached); err != nil {
+		t.Fatalf("createService failed: %v", err)
+	}
+	if _, err := c.createHeadlessService(memcached); err != nil {
+		t.Fatalf("createHeadlessService failed: %v", err)
+	}
+
+	primary, err := c.Client.CoreV1().Services(memcached.Namespace).Get(memcached.OffshootName(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the primary Service to exist: %v", err)
+	}
+	if primary.Spec.ClusterIP == core.ClusterIPNone {
+		t.Fatal("primary Service must stay a normal ClusterIP Service when only Spec.Headless is set")
+	}
+
+	headless, err := c.Client.CoreV1().Services(memcached.Namespace).Get(headlessServiceName(memcached), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the headless companion Service to exist: %v", err)
+	}
+	if headless.Spec.ClusterIP != core.ClusterIPNone {
+		t.Fatalf("expected the headless companion's ClusterIP to be None, got %q", headless.Spec.ClusterIP)
+	}
+	if !headless.Spec.PublishNotReadyAddresses {
+		t.Fatal("expected PublishNotReadyAddresses to propagate onto the headless companion")
+	}
+}
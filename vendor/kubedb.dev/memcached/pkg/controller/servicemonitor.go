@@ -0,0 +1,180 @@
+/*
+Copyright The KubeDB Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/pkg/eventer"
+
+	"github.com/appscode/go/log"
+	promapi "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/google/go-cmp/cmp"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kutil "kmodules.xyz/client-go"
+	mona "kmodules.xyz/monitoring-agent-api/api/v1"
+)
+
+const podMonitorMode = "pod"
+
+// ensureMonitoringResource reconciles the Prometheus Operator ServiceMonitor
+// (or PodMonitor, when Spec.Monitor.Prometheus.Mode is "pod") that scrapes
+// the stats Service/pods, mirroring the scrape settings configured on the
+// Memcached CR. It no-ops when monitoring isn't Prometheus or the
+// Prometheus Operator CRDs aren't installed on the cluster, so clusters
+// that only run the builtin Prometheus agent are unaffected.
+func (c *Controller) ensureMonitoringResource(memcached *api.Memcached, owner *metav1.OwnerReference) (kutil.VerbType, error) {
+	if memcached.GetMonitoringVendor() != mona.VendorPrometheus {
+		return kutil.VerbUnchanged, nil
+	}
+	if memcached.Spec.Monitor.Prometheus == nil || memcached.Spec.Monitor.Prometheus.ServiceMonitor == nil {
+		return kutil.VerbUnchanged, nil
+	}
+	if !c.prometheusOperatorCRDsInstalled() {
+		c.recorder.Event(
+			memcached,
+			core.EventTypeWarning,
+			eventer.EventReasonFailedToCreate,
+			"Prometheus Operator CRDs are not installed; skipping ServiceMonitor/PodMonitor reconciliation",
+		)
+		log.Infoln("monitoring.coreos.com/v1 CRDs are not installed, skipping ServiceMonitor/PodMonitor reconciliation")
+		return kutil.VerbUnchanged, nil
+	}
+
+	if memcached.Spec.Monitor.Prometheus.Mode == podMonitorMode {
+		return c.ensurePodMonitor(memcached, owner)
+	}
+	return c.ensureServiceMonitor(memcached, owner)
+}
+
+func (c *Controller) ensureServiceMonitor(memcached *api.Memcached, owner *metav1.OwnerReference) (kutil.VerbType, error) {
+	sm := memcached.Spec.Monitor.Prometheus.ServiceMonitor
+
+	existing, err := c.PromClient.MonitoringV1().ServiceMonitors(memcached.Namespace).Get(memcached.StatsService().ServiceName(), metav1.GetOptions{})
+	notFound := kerr.IsNotFound(err)
+	if err != nil && !notFound {
+		return kutil.VerbUnchanged, err
+	}
+
+	desired := &promapi.ServiceMonitor{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            memcached.StatsService().ServiceName(),
+			Namespace:       memcached.Namespace,
+			Labels:          memcached.StatsServiceLabels(),
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: promapi.ServiceMonitorSpec{
+			Selector: metav1.LabelSelector{MatchLabels: memcached.StatsServiceLabels()},
+			NamespaceSelector: promapi.NamespaceSelector{
+				MatchNames: []string{memcached.Namespace},
+			},
+			Endpoints: []promapi.Endpoint{
+				{
+					Port:           api.PrometheusExporterPortName,
+					Path:           sm.Path,
+					Interval:       sm.Interval,
+					HonorLabels:    sm.HonorLabels,
+					TLSConfig:      sm.TLSConfig,
+					RelabelConfigs: sm.RelabelConfigs,
+				},
+			},
+		},
+	}
+
+	if notFound {
+		if _, err := c.PromClient.MonitoringV1().ServiceMonitors(memcached.Namespace).Create(desired); err != nil {
+			return kutil.VerbUnchanged, err
+		}
+		return kutil.VerbCreated, nil
+	}
+
+	if cmp.Equal(existing.Labels, desired.Labels) && cmp.Equal(existing.OwnerReferences, desired.OwnerReferences) && cmp.Equal(existing.Spec, desired.Spec) {
+		return kutil.VerbUnchanged, nil
+	}
+	desired.ResourceVersion = existing.ResourceVersion
+	if _, err := c.PromClient.MonitoringV1().ServiceMonitors(memcached.Namespace).Update(desired); err != nil {
+		return kutil.VerbUnchanged, err
+	}
+	return kutil.VerbPatched, nil
+}
+
+func (c *Controller) ensurePodMonitor(memcached *api.Memcached, owner *metav1.OwnerReference) (kutil.VerbType, error) {
+	sm := memcached.Spec.Monitor.Prometheus.ServiceMonitor
+
+	existing, err := c.PromClient.MonitoringV1().PodMonitors(memcached.Namespace).Get(memcached.StatsService().ServiceName(), metav1.GetOptions{})
+	notFound := kerr.IsNotFound(err)
+	if err != nil && !notFound {
+		return kutil.VerbUnchanged, err
+	}
+
+	desired := &promapi.PodMonitor{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            memcached.StatsService().ServiceName(),
+			Namespace:       memcached.Namespace,
+			Labels:          memcached.StatsServiceLabels(),
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: promapi.PodMonitorSpec{
+			Selector: metav1.LabelSelector{MatchLabels: memcached.OffshootSelectors()},
+			NamespaceSelector: promapi.NamespaceSelector{
+				MatchNames: []string{memcached.Namespace},
+			},
+			PodMetricsEndpoints: []promapi.PodMetricsEndpoint{
+				{
+					Port:           api.PrometheusExporterPortName,
+					Path:           sm.Path,
+					Interval:       sm.Interval,
+					HonorLabels:    sm.HonorLabels,
+					TLSConfig:      sm.PodTLSConfig,
+					RelabelConfigs: sm.RelabelConfigs,
+				},
+			},
+		},
+	}
+
+	if notFound {
+		if _, err := c.PromClient.MonitoringV1().PodMonitors(memcached.Namespace).Create(desired); err != nil {
+			return kutil.VerbUnchanged, err
+		}
+		return kutil.VerbCreated, nil
+	}
+
+	if cmp.Equal(existing.Labels, desired.Labels) && cmp.Equal(existing.OwnerReferences, desired.OwnerReferences) && cmp.Equal(existing.Spec, desired.Spec) {
+		return kutil.VerbUnchanged, nil
+	}
+	desired.ResourceVersion = existing.ResourceVersion
+	if _, err := c.PromClient.MonitoringV1().PodMonitors(memcached.Namespace).Update(desired); err != nil {
+		return kutil.VerbUnchanged, err
+	}
+	return kutil.VerbPatched, nil
+}
+
+// prometheusOperatorCRDsInstalled reports whether the Prometheus Operator
+// CRDs are registered with the API server. The result is discovered once,
+// at controller startup (see DetectOptionalCRDs), and cached on the
+// Controller so the reconcile hot path never re-hits discovery.
+func (c *Controller) prometheusOperatorCRDsInstalled() bool {
+	return c.promInstalled
+}
+
+// detectPrometheusOperatorCRDs is invoked once from DetectOptionalCRDs at
+// controller startup to populate promInstalled.
+func (c *Controller) detectPrometheusOperatorCRDs() {
+	_, err := c.Client.Discovery().ServerResourcesForGroupVersion(promapi.SchemeGroupVersion.String())
+	c.promInstalled = err == nil
+}
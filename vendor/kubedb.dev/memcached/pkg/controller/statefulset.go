@@ -0,0 +1,166 @@
+/*
+Copyright The KubeDB Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strconv"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+	"kubedb.dev/apimachinery/pkg/eventer"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kutil "kmodules.xyz/client-go"
+	app_util "kmodules.xyz/client-go/apps/v1"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+// memcachedContainerName is the name of the StatefulSet container running
+// the memcached binary.
+const memcachedContainerName = "memcached"
+
+// ensureStatefulSet reconciles the StatefulSet running the memcached
+// binary, following the same CreateOrPatch idiom as ensureService.
+func (c *Controller) ensureStatefulSet(memcached *api.Memcached) (kutil.VerbType, error) {
+	meta := metav1.ObjectMeta{
+		Name:      memcached.OffshootName(),
+		Namespace: memcached.Namespace,
+	}
+
+	owner := metav1.NewControllerRef(memcached, api.SchemeGroupVersion.WithKind(api.ResourceKindMemcached))
+
+	_, vt, err := app_util.CreateOrPatchStatefulSet(c.Client, meta, func(in *apps.StatefulSet) *apps.StatefulSet {
+		core_util.EnsureOwnerReference(&in.ObjectMeta, owner)
+		in.Labels = memcached.OffshootLabels()
+		in.Spec.ServiceName = memcached.ServiceName()
+		in.Spec.Replicas = memcached.Spec.Replicas
+		in.Spec.Selector = &metav1.LabelSelector{MatchLabels: memcached.OffshootSelectors()}
+		in.Spec.Template.Labels = memcached.OffshootSelectors()
+		in.Spec.Template.Spec = ensureMemcachedPodSpec(memcached, in.Spec.Template.Spec)
+		return in
+	})
+	if err != nil {
+		return kutil.VerbUnchanged, err
+	} else if vt != kutil.VerbUnchanged {
+		c.recorder.Eventf(
+			memcached,
+			core.EventTypeNormal,
+			eventer.EventReasonSuccessful,
+			"Successfully %s StatefulSet",
+			vt,
+		)
+	}
+	return vt, nil
+}
+
+// ensureMemcachedPodSpec builds the Pod template for the memcached
+// container: the "db" containerPort and its "-p <port>" CLI argument are
+// always present, ApplyUDPPodSpec layers the optional UDP wiring on top
+// (so Spec.UDP.Enabled actually reaches the running process), and
+// DualStackReadinessProbe supplies the probe used for both readiness and
+// liveness.
+func ensureMemcachedPodSpec(memcached *api.Memcached, podSpec core.PodSpec) core.PodSpec {
+	var container *core.Container
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == memcachedContainerName {
+			container = &podSpec.Containers[i]
+			break
+		}
+	}
+	if container == nil {
+		podSpec.Containers = append(podSpec.Containers, core.Container{Name: memcachedContainerName})
+		container = &podSpec.Containers[len(podSpec.Containers)-1]
+	}
+
+	container.Image = memcached.Spec.Image
+	container.Ports = []core.ContainerPort{
+		{Name: defaultDBPort.Name, ContainerPort: defaultDBPort.Port, Protocol: core.ProtocolTCP},
+	}
+	container.Args = []string{"-p", strconv.Itoa(int(defaultDBPort.Port))}
+	container.ReadinessProbe = DualStackReadinessProbe()
+	container.LivenessProbe = DualStackReadinessProbe()
+
+	ApplyUDPPodSpec(memcached, &podSpec)
+
+	return podSpec
+}
+
+// ApplyUDPPodSpec wires Spec.UDP.Enabled onto a pod template: it adds the
+// db-udp containerPort alongside the existing db one, and appends memcached's
+// "-U <port>" CLI flag so the process actually listens on it. It is
+// idempotent so ensureMemcachedPodSpec can call it on every reconcile
+// without accumulating duplicate ports or CLI args on a PodSpec that
+// already has them from a previous pass.
+func ApplyUDPPodSpec(memcached *api.Memcached, podSpec *core.PodSpec) {
+	udp := udpDBPort(memcached)
+	if udp == nil {
+		return
+	}
+
+	udpArg := []string{"-U", strconv.Itoa(int(udp.Port))}
+
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if container.Name != memcachedContainerName {
+			continue
+		}
+
+		hasPort := false
+		for _, p := range container.Ports {
+			if p.Name == udp.Name {
+				hasPort = true
+				break
+			}
+		}
+		if !hasPort {
+			container.Ports = append(container.Ports, core.ContainerPort{
+				Name:          udp.Name,
+				ContainerPort: udp.Port,
+				Protocol:      core.ProtocolUDP,
+			})
+		}
+
+		hasArg := false
+		for j := 0; j+1 < len(container.Args); j++ {
+			if container.Args[j] == udpArg[0] && container.Args[j+1] == udpArg[1] {
+				hasArg = true
+				break
+			}
+		}
+		if !hasArg {
+			container.Args = append(container.Args, udpArg...)
+		}
+	}
+}
+
+// DualStackReadinessProbe returns the readiness/liveness probe used for the
+// memcached container. A TCPSocket probe by containerPort name, with no
+// Host set, dials whichever address family the kubelet reached the Pod on
+// for that probe, so it already works unchanged once a Service requests
+// dual-stack; this helper exists so ensureMemcachedPodSpec has one place
+// to get it from instead of constructing it ad hoc.
+func DualStackReadinessProbe() *core.Probe {
+	return &core.Probe{
+		Handler: core.Handler{
+			TCPSocket: &core.TCPSocketAction{
+				Port: intstr.FromString(defaultDBPort.Name),
+			},
+		},
+	}
+}
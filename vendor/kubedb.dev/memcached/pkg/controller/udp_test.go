@@ -0,0 +1,128 @@
+/*
+Copyright The KubeDB Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	api "kubedb.dev/apimachinery/apis/kubedb/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+)
+
+func TestUdpDBPort(t *testing.T) {
+	disabled := &api.Memcached{}
+	if got := udpDBPort(disabled); got != nil {
+		t.Fatalf("expected nil when spec.udp.enabled is false, got %+v", got)
+	}
+
+	defaultPort := &api.Memcached{}
+	defaultPort.Spec.UDP.Enabled = true
+	udp := udpDBPort(defaultPort)
+	if udp == nil || udp.Port != defaultUDPPort {
+		t.Fatalf("expected default UDP port %d, got %+v", defaultUDPPort, udp)
+	}
+
+	customPort := &api.Memcached{}
+	customPort.Spec.UDP.Enabled = true
+	customPort.Spec.UDP.Port = 12345
+	udp = udpDBPort(customPort)
+	if udp == nil || udp.Port != 12345 {
+		t.Fatalf("expected custom UDP port 12345, got %+v", udp)
+	}
+}
+
+func TestApplyUDPPodSpec(t *testing.T) {
+	memcached := &api.Memcached{}
+	memcached.Spec.UDP.Enabled = true
+
+	podSpec := &core.PodSpec{
+		Containers: []core.Container{
+			{Name: memcachedContainerName},
+		},
+	}
+	ApplyUDPPodSpec(memcached, podSpec)
+
+	container := podSpec.Containers[0]
+	if len(container.Ports) != 1 || container.Ports[0].Name != "db-udp" || container.Ports[0].Protocol != core.ProtocolUDP {
+		t.Fatalf("expected a single db-udp UDP containerPort, got %+v", container.Ports)
+	}
+	if len(container.Args) != 2 || container.Args[0] != "-U" || container.Args[1] != "11211" {
+		t.Fatalf("expected -U 11211 args, got %v", container.Args)
+	}
+}
+
+func TestApplyUDPPodSpec_IdempotentAcrossRepeatedCalls(t *testing.T) {
+	memcached := &api.Memcached{}
+	memcached.Spec.UDP.Enabled = true
+
+	podSpec := &core.PodSpec{
+		Containers: []core.Container{
+			{Name: memcachedContainerName},
+		},
+	}
+
+	// A patched/re-fetched PodSpec from a prior reconcile already carries
+	// the port and arg; applying again must not duplicate either.
+	ApplyUDPPodSpec(memcached, podSpec)
+	ApplyUDPPodSpec(memcached, podSpec)
+	ApplyUDPPodSpec(memcached, podSpec)
+
+	container := podSpec.Containers[0]
+	if len(container.Ports) != 1 {
+		t.Fatalf("expected exactly one db-udp containerPort after repeated calls, got %+v", container.Ports)
+	}
+	if len(container.Args) != 2 {
+		t.Fatalf("expected exactly one -U <port> arg pair after repeated calls, got %v", container.Args)
+	}
+}
+
+func TestEnsureMemcachedPodSpec_WiresUDPAndReadinessProbe(t *testing.T) {
+	memcached := &api.Memcached{}
+	memcached.Spec.UDP.Enabled = true
+
+	podSpec := ensureMemcachedPodSpec(memcached, core.PodSpec{})
+
+	if len(podSpec.Containers) != 1 {
+		t.Fatalf("expected a single memcached container, got %d", len(podSpec.Containers))
+	}
+	container := podSpec.Containers[0]
+
+	var hasUDPPort bool
+	for _, p := range container.Ports {
+		if p.Name == "db-udp" && p.Protocol == core.ProtocolUDP {
+			hasUDPPort = true
+		}
+	}
+	if !hasUDPPort {
+		t.Fatalf("expected ensureMemcachedPodSpec to wire the db-udp containerPort, got %+v", container.Ports)
+	}
+
+	var hasUDPArg bool
+	for i := 0; i+1 < len(container.Args); i++ {
+		if container.Args[i] == "-U" && container.Args[i+1] == "11211" {
+			hasUDPArg = true
+		}
+	}
+	if !hasUDPArg {
+		t.Fatalf("expected ensureMemcachedPodSpec to append -U 11211, got %v", container.Args)
+	}
+
+	if container.ReadinessProbe == nil || container.ReadinessProbe.TCPSocket == nil {
+		t.Fatal("expected ensureMemcachedPodSpec to set a TCPSocket readiness probe")
+	}
+}